@@ -0,0 +1,222 @@
+// Resolves AWS credentials from a configurable chain, so the enumerator
+// can run from places that don't have a ~/.aws/credentials file: Lambda,
+// ECS tasks, EKS pods (IRSA), or a bare CI runner.
+//
+// Author: Trent Clostio (twclostio@gmail.com)
+// License: MIT
+//
+
+package credprovider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// AuthMode selects which credential source Resolve builds a provider for.
+type AuthMode string
+
+const (
+	// AuthModeChain tries, in order: environment variables, the shared
+	// profile, EC2 IMDS instance-role credentials, then web identity
+	// (IRSA/OIDC) if a token file was given. It's the default.
+	AuthModeChain AuthMode = "chain"
+	// AuthModeProfile uses exactly the named shared config/credentials
+	// profile.
+	AuthModeProfile AuthMode = "profile"
+	// AuthModeEnv uses only AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY /
+	// AWS_SESSION_TOKEN from the environment.
+	AuthModeEnv AuthMode = "env"
+	// AuthModeIMDS uses EC2 instance metadata role credentials.
+	AuthModeIMDS AuthMode = "imds"
+	// AuthModeWebIdentity exchanges a web identity token (e.g. an EKS
+	// IRSA-projected service account token) for role credentials via STS.
+	AuthModeWebIdentity AuthMode = "webidentity"
+	// AuthModeSSO uses AWS IAM Identity Center (SSO) cached credentials
+	// for the named profile.
+	AuthModeSSO AuthMode = "sso"
+	// AuthModeProcess uses the `credential_process` configured for the
+	// named profile in the shared config file.
+	AuthModeProcess AuthMode = "process"
+)
+
+// Options configures Resolve.
+type Options struct {
+	// AuthMode selects the credential source. Defaults to AuthModeChain.
+	AuthMode AuthMode
+	// Profile is the shared config/credentials profile name, used by
+	// AuthModeProfile, AuthModeSSO, AuthModeProcess, and as a fallback
+	// step of AuthModeChain.
+	Profile string
+	// Region is required to construct the STS client used for
+	// AuthModeWebIdentity.
+	Region string
+	// WebIdentityTokenFile is the path to the OIDC token used by
+	// AuthModeWebIdentity, e.g. the IRSA-projected service account token
+	// at AWS_WEB_IDENTITY_TOKEN_FILE.
+	WebIdentityTokenFile string
+	// WebIdentityRoleArn is the role assumed via AssumeRoleWithWebIdentity.
+	WebIdentityRoleArn string
+	// RoleSessionName is used for AuthModeWebIdentity's assumed session.
+	RoleSessionName string
+	// ExternalID is reserved for auth modes that call AssumeRole (which
+	// accepts an external ID); AssumeRoleWithWebIdentity has no such
+	// parameter, so it is ignored by AuthModeWebIdentity.
+	ExternalID string
+}
+
+// Resolve builds an aws.CredentialsProvider for the requested auth mode.
+// The returned provider is intended to be passed to both the bootstrap
+// config and the assumed-role config, so a caller that authenticates via
+// IMDS or web identity doesn't silently fall back to the default profile
+// when constructing the second config.
+func Resolve(ctx context.Context, opts Options) (aws.CredentialsProvider, error) {
+	switch opts.AuthMode {
+	case "", AuthModeChain:
+		return resolveChain(ctx, opts)
+	case AuthModeProfile:
+		return resolveProfile(ctx, opts)
+	case AuthModeEnv:
+		return resolveEnv(ctx)
+	case AuthModeIMDS:
+		return resolveIMDS(), nil
+	case AuthModeWebIdentity:
+		return resolveWebIdentity(ctx, opts)
+	case AuthModeSSO:
+		return resolveSSO(ctx, opts)
+	case AuthModeProcess:
+		return resolveProfile(ctx, opts)
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", opts.AuthMode)
+	}
+}
+
+// resolveChain tries, in order, env vars, the shared profile, EC2 IMDS,
+// then web identity (if a token file was configured), returning the first
+// provider that can actually retrieve credentials.
+func resolveChain(ctx context.Context, opts Options) (aws.CredentialsProvider, error) {
+	var providers []aws.CredentialsProvider
+
+	if envProvider, err := resolveEnv(ctx); err == nil {
+		providers = append(providers, envProvider)
+	}
+
+	if opts.Profile != "" {
+		if profileProvider, err := resolveProfile(ctx, opts); err == nil {
+			providers = append(providers, profileProvider)
+		}
+	}
+
+	providers = append(providers, resolveIMDS())
+
+	if opts.WebIdentityTokenFile != "" {
+		if webIdentityProvider, err := resolveWebIdentity(ctx, opts); err == nil {
+			providers = append(providers, webIdentityProvider)
+		} else {
+			slog.Warn("skipping web identity provider in credential chain", "error", err)
+		}
+	}
+
+	return aws.NewCredentialsCache(&chainProvider{providers: providers}), nil
+}
+
+// chainProvider tries each provider in order and returns the first
+// credentials set that retrieves without error.
+type chainProvider struct {
+	providers []aws.CredentialsProvider
+}
+
+func (c *chainProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	var lastErr error
+	for _, p := range c.providers {
+		creds, err := p.Retrieve(ctx)
+		if err == nil {
+			return creds, nil
+		}
+		lastErr = err
+	}
+	return aws.Credentials{}, fmt.Errorf("no credential provider in the chain succeeded: %w", lastErr)
+}
+
+// resolveEnv returns a provider backed by AWS_ACCESS_KEY_ID /
+// AWS_SECRET_ACCESS_KEY / AWS_SESSION_TOKEN.
+func resolveEnv(ctx context.Context) (aws.CredentialsProvider, error) {
+	envCfg, err := config.NewEnvConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve environment credentials: %w", err)
+	}
+	if !envCfg.Credentials.HasKeys() {
+		return nil, fmt.Errorf("no AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY set in the environment")
+	}
+	return credentials.StaticCredentialsProvider{Value: envCfg.Credentials}, nil
+}
+
+// resolveProfile returns a provider backed by the named shared
+// config/credentials profile, including any configured credential_process
+// or SSO session.
+func resolveProfile(ctx context.Context, opts Options) (aws.CredentialsProvider, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(opts.Profile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve profile %q credentials: %w", opts.Profile, err)
+	}
+	return cfg.Credentials, nil
+}
+
+// resolveIMDS returns a provider backed by the EC2 instance metadata
+// service's attached instance-profile role.
+func resolveIMDS() aws.CredentialsProvider {
+	return aws.NewCredentialsCache(ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		o.Client = imds.New(imds.Options{})
+	}))
+}
+
+// resolveWebIdentity exchanges a web identity token (e.g. an IRSA service
+// account token) for role credentials via AssumeRoleWithWebIdentity.
+func resolveWebIdentity(ctx context.Context, opts Options) (aws.CredentialsProvider, error) {
+	if opts.WebIdentityRoleArn == "" {
+		return nil, fmt.Errorf("web identity auth mode requires a role ARN")
+	}
+	if opts.WebIdentityTokenFile == "" {
+		return nil, fmt.Errorf("web identity auth mode requires --web-identity-token-file")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(opts.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load base config for web identity provider: %w", err)
+	}
+
+	// AssumeRoleWithWebIdentity, unlike AssumeRole, takes no external ID,
+	// so opts.ExternalID is ignored in this auth mode.
+	stsClient := sts.NewFromConfig(cfg)
+	provider := stscreds.NewWebIdentityRoleProvider(stsClient, opts.WebIdentityRoleArn,
+		stscreds.IdentityTokenFile(opts.WebIdentityTokenFile),
+		func(o *stscreds.WebIdentityRoleOptions) {
+			if opts.RoleSessionName != "" {
+				o.RoleSessionName = opts.RoleSessionName
+			}
+		},
+	)
+
+	return aws.NewCredentialsCache(provider), nil
+}
+
+// resolveSSO returns a provider backed by the named profile's cached AWS
+// IAM Identity Center (SSO) credentials.
+func resolveSSO(ctx context.Context, opts Options) (aws.CredentialsProvider, error) {
+	// config.LoadDefaultConfig resolves SSO credentials itself from the
+	// profile's sso_* settings; no separate ssocreds wiring is needed.
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(opts.Profile), config.WithRegion(opts.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve SSO profile %q credentials: %w", opts.Profile, err)
+	}
+	return cfg.Credentials, nil
+}
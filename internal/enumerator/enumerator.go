@@ -0,0 +1,247 @@
+// Implements the bucket-owner account enumeration algorithm: an assumed
+// role's inline policy is rewritten one digit at a time with a StringLike
+// condition on aws:ResourceAccount, and the resulting access (or denial)
+// to the target bucket reveals whether that digit belongs to the owning
+// account.
+//
+// Author: Trent Clostio (twclostio@gmail.com)
+// License: MIT
+//
+
+package enumerator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithy "github.com/aws/smithy-go"
+
+	"github.com/tclostio/s3-account-finder/internal"
+	"github.com/tclostio/s3-account-finder/internal/metrics"
+)
+
+// accountIDDigits is the fixed length of an AWS account ID.
+const accountIDDigits = 12
+
+// enumerationPolicyName is the inline policy attached (and rewritten) on
+// the role for the duration of the enumeration.
+const enumerationPolicyName = "S3EnumerationPolicy"
+
+const (
+	// defaultConsistencyWait is how long we wait between PutRolePolicy and
+	// probing the bucket, to give IAM eventual consistency a chance to
+	// catch up.
+	defaultConsistencyWait = 2 * time.Second
+	// defaultConsistencyTimeout is the total time we're willing to retry a
+	// single digit probe before giving up on it as inconclusive.
+	defaultConsistencyTimeout = 20 * time.Second
+	// defaultConcurrency is the default for Options.Concurrency, which has
+	// no effect: candidate digits are always probed one at a time.
+	defaultConcurrency = 4
+)
+
+// Options controls the enumeration algorithm.
+type Options struct {
+	// Concurrency is reserved for a future version that probes multiple
+	// candidate digits in parallel. It is currently a no-op: the ten
+	// candidates at a digit position are always probed one at a time,
+	// because they all rewrite the same shared inline policy on roleName
+	// - probing two candidates concurrently means one goroutine's
+	// HeadBucket call can be evaluated against the other's just-written
+	// condition, corrupting the result. Giving each candidate its own
+	// ephemeral role/policy would make real concurrency safe, but that's
+	// more machinery than this package needs yet.
+	Concurrency int
+	// ConsistencyWait is the delay after PutRolePolicy before the first
+	// probe attempt, to account for IAM eventual consistency. Defaults to
+	// defaultConsistencyWait.
+	ConsistencyWait time.Duration
+	// ConsistencyTimeout bounds the total retry time for a single digit
+	// probe. Defaults to defaultConsistencyTimeout.
+	ConsistencyTimeout time.Duration
+	// StartingPrefix resumes enumeration from a previously-known prefix of
+	// the account ID, rather than starting from scratch.
+	StartingPrefix string
+	// S3OptFns are additional s3.Options functional options (e.g.
+	// UsePathStyle for S3-compatible providers) applied to the S3 client
+	// used to probe the bucket.
+	S3OptFns []func(*s3.Options)
+	// IAMOptFns are additional iam.Options functional options (e.g.
+	// metrics.InstrumentAPIOptions) applied to the IAM client used to
+	// rewrite the enumeration policy.
+	IAMOptFns []func(*iam.Options)
+	// RPS and Burst configure the token-bucket rate limit applied to the
+	// bucket probes. Non-positive values fall back to
+	// internal.NewRateLimitedS3Client's defaults.
+	RPS   float64
+	Burst int
+}
+
+// DigitAttempt records the outcome of probing a single candidate digit at
+// a single position, for audit purposes.
+type DigitAttempt struct {
+	Position int
+	Digit    int
+	Allowed  bool
+	Err      error
+}
+
+// EnumerateBucketOwner uses cfg's credentials, as they are, both to
+// rewrite roleName's inline policy and to probe bucket; it does not assume
+// roleName itself. For each digit of the 12-digit account ID it iterates
+// candidates 0-9 by rewriting the role's inline policy with a StringLike
+// condition on aws:ResourceAccount, then probes bucket with HeadBucket.
+// A non-AccessDenied
+// response proves the candidate digit is correct. It returns the
+// discovered account ID and the full per-digit audit trail.
+func EnumerateBucketOwner(ctx context.Context, cfg aws.Config, roleName, bucket string, opts Options) (string, []DigitAttempt, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.ConsistencyWait <= 0 {
+		opts.ConsistencyWait = defaultConsistencyWait
+	}
+	if opts.ConsistencyTimeout <= 0 {
+		opts.ConsistencyTimeout = defaultConsistencyTimeout
+	}
+	if len(opts.StartingPrefix) >= accountIDDigits {
+		return "", nil, fmt.Errorf("starting prefix %q is already a full account ID", opts.StartingPrefix)
+	}
+
+	iamClient := iam.NewFromConfig(cfg, opts.IAMOptFns...)
+	s3Client := internal.NewRateLimitedS3Client(cfg, opts.RPS, opts.Burst, opts.S3OptFns...)
+
+	prefix := opts.StartingPrefix
+	var audit []DigitAttempt
+	metrics.EnumerationDigitsResolved.Set(float64(len(prefix)))
+
+	for position := len(prefix); position < accountIDDigits; position++ {
+		digit, attempts, err := probePosition(ctx, iamClient, s3Client, roleName, bucket, prefix, position, opts)
+		audit = append(audit, attempts...)
+		if err != nil {
+			return "", audit, fmt.Errorf("resolving digit %d of account ID (known prefix %q): %w", position, prefix, err)
+		}
+		prefix += strconv.Itoa(digit)
+		metrics.EnumerationDigitsResolved.Set(float64(len(prefix)))
+	}
+
+	return prefix, audit, nil
+}
+
+// probePosition tests each of the ten candidate digits at position, one at
+// a time, and returns the first one that proves to belong to the bucket's
+// owning account. Candidates cannot be probed concurrently: they all
+// rewrite the same shared inline policy on roleName, so testing two at
+// once would let one goroutine's probe be evaluated against another's
+// just-written condition.
+func probePosition(ctx context.Context, iamClient *iam.Client, s3Client *internal.RateLimitedS3Client, roleName, bucket, prefix string, position int, opts Options) (int, []DigitAttempt, error) {
+	var attempts []DigitAttempt
+
+	for digit := 0; digit <= 9; digit++ {
+		allowed, err := probeDigit(ctx, iamClient, s3Client, roleName, bucket, prefix, digit, opts)
+		attempts = append(attempts, DigitAttempt{Position: position, Digit: digit, Allowed: allowed, Err: err})
+		if allowed {
+			return digit, attempts, nil
+		}
+	}
+
+	return 0, attempts, errors.New("all ten digits denied access; target account may not be in range or bucket is unreachable")
+}
+
+// probeDigit rewrites the role's inline policy to scope access to accounts
+// matching prefix+digit+"*", waits for IAM eventual consistency, and probes
+// the bucket. It returns true if the candidate digit is allowed.
+func probeDigit(ctx context.Context, iamClient *iam.Client, s3Client *internal.RateLimitedS3Client, roleName, bucket, prefix string, digit int, opts Options) (bool, error) {
+	pattern := fmt.Sprintf("%s%d*", prefix, digit)
+	if err := putEnumerationPolicy(ctx, iamClient, roleName, bucket, pattern); err != nil {
+		return false, fmt.Errorf("attaching policy for pattern %q: %w", pattern, err)
+	}
+
+	deadline := time.Now().Add(opts.ConsistencyTimeout)
+	time.Sleep(opts.ConsistencyWait)
+
+	for {
+		_, err := s3Client.HeadBucketWithRetry(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		if err == nil {
+			return true, nil
+		}
+		if !isAccessDenied(err) {
+			// Any non-AccessDenied failure (bucket missing, throttling that
+			// survived the SDK's own retries, etc.) means this probe is
+			// inconclusive rather than a firm "no".
+			return false, err
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(opts.ConsistencyWait):
+		}
+	}
+}
+
+// putEnumerationPolicy rewrites the role's inline enumeration policy so
+// that S3 access to bucket is only permitted when aws:ResourceAccount
+// matches pattern.
+func putEnumerationPolicy(ctx context.Context, iamClient *iam.Client, roleName, bucket, pattern string) error {
+	policyDoc := internal.PolicyDocument{
+		Version: "2012-10-17",
+		Statement: []internal.PolicyStatement{
+			{
+				Sid:      "EnumerateBucketOwnerAccount",
+				Effect:   "Allow",
+				Action:   []string{"s3:ListBucket", "s3:GetObject"},
+				Resource: aws.String(fmt.Sprintf("arn:aws:s3:::%s", bucket)),
+				Condition: map[string]map[string]interface{}{
+					"StringLike": {
+						"aws:ResourceAccount": pattern,
+					},
+				},
+			},
+		},
+	}
+
+	policyBytes, err := json.Marshal(policyDoc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal enumeration policy: %w", err)
+	}
+
+	_, err = iamClient.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(enumerationPolicyName),
+		PolicyDocument: aws.String(string(policyBytes)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put role policy: %w", err)
+	}
+
+	return nil
+}
+
+// isAccessDenied reports whether err is an AWS AccessDenied error, either
+// from IAM policy evaluation or the S3 API itself.
+func isAccessDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "Forbidden", "403":
+			return true
+		}
+	}
+
+	return strings.Contains(err.Error(), "AccessDenied")
+}
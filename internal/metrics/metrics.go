@@ -0,0 +1,112 @@
+// Prometheus instrumentation for the AWS API calls the enumerator makes,
+// and the structured logger used to report on them.
+//
+// Author: Trent Clostio (twclostio@gmail.com)
+// License: MIT
+//
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// IAMPutRolePolicyTotal counts PutRolePolicy calls, the hot path of
+	// the digit-enumeration loop.
+	IAMPutRolePolicyTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iam_put_role_policy_total",
+		Help: "Total number of IAM PutRolePolicy calls made while rewriting the enumeration policy.",
+	})
+
+	// S3HeadBucketTotal counts HeadBucket calls, the hot path of the
+	// digit-enumeration loop's bucket probe.
+	S3HeadBucketTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "s3_head_bucket_total",
+		Help: "Total number of S3 HeadBucket calls made while probing a bucket.",
+	})
+
+	// RequestDuration observes the latency of every instrumented AWS API
+	// call, labeled by service and operation, so operators can graph
+	// throttle rates during a large enumeration sweep.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aws_request_duration_seconds",
+		Help:    "Latency of AWS API calls made by s3-account-finder, labeled by service, operation, and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation", "outcome"})
+
+	// EnumerationDigitsResolved tracks how far the current enumeration
+	// run has progressed through the 12-digit account ID.
+	EnumerationDigitsResolved = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "enumeration_digits_resolved",
+		Help: "Number of account ID digits resolved so far in the current enumeration run.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(IAMPutRolePolicyTotal, S3HeadBucketTotal, RequestDuration, EnumerationDigitsResolved)
+}
+
+// InstrumentAPIOptions returns a Smithy middleware-stack mutator suitable
+// for s3.Options.APIOptions or iam.Options.APIOptions. It observes every
+// SDK call transparently: request latency goes to RequestDuration, and
+// PutRolePolicy/HeadBucket calls additionally bump their dedicated
+// counters.
+func InstrumentAPIOptions(serviceID string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		// Added with After so it runs after RegisterServiceMetadata (added
+		// with Before, i.e. at the front of Initialize), by which point the
+		// operation name is already set on the context.
+		return stack.Initialize.Add(
+			middleware.InitializeMiddlewareFunc("MetricsObserver", func(
+				ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+			) (middleware.InitializeOutput, middleware.Metadata, error) {
+				operation := awsmiddleware.GetOperationName(ctx)
+				start := time.Now()
+
+				out, md, err := next.HandleInitialize(ctx, in)
+
+				outcome := "success"
+				if err != nil {
+					outcome = "error"
+				}
+				RequestDuration.WithLabelValues(serviceID, operation, outcome).Observe(time.Since(start).Seconds())
+
+				switch operation {
+				case "PutRolePolicy":
+					IAMPutRolePolicyTotal.Inc()
+				case "HeadBucket":
+					S3HeadBucketTotal.Inc()
+				}
+
+				return out, md, err
+			}),
+			middleware.After,
+		)
+	}
+}
+
+// StartServer starts an HTTP server exposing /metrics on addr (e.g.
+// ":9090") in the background. It returns immediately; callers that want a
+// clean shutdown can call srv.Shutdown themselves.
+func StartServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("[!] metrics server stopped: %v\n", err)
+		}
+	}()
+
+	return srv
+}
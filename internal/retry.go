@@ -1,4 +1,4 @@
-// Retry logic and rate limiting utilities for AWS API calls
+// Rate limiting and retry utilities for AWS API calls.
 //
 // Author: Trent Clostio (twclostio@gmail.com)
 // License: MIT
@@ -8,131 +8,91 @@ package internal
 
 import (
 	"context"
-	"fmt"
-	"math"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/time/rate"
 )
 
+// defaultRPS and defaultBurst are used when NewRateLimitedS3Client is
+// given a non-positive rps or burst.
 const (
-	maxRetries        = 3
-	baseDelay         = 1 * time.Second
-	maxDelay          = 30 * time.Second
-	rateLimitDelay    = 100 * time.Millisecond
+	defaultRPS         = 10
+	defaultBurst       = 5
+	defaultMaxAttempts = 5
 )
 
-// RetryableFunc represents a function that can be retried
-type RetryableFunc func() error
-
-// WithRetry executes a function with exponential backoff retry logic
-func WithRetry(ctx context.Context, fn RetryableFunc) error {
-	var lastErr error
-	
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			// Calculate exponential backoff delay
-			delay := time.Duration(math.Min(float64(baseDelay)*math.Pow(2, float64(attempt-1)), float64(maxDelay)))
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(delay):
-			}
-		}
-		
-		if err := fn(); err != nil {
-			lastErr = err
-			// Check if error is retryable
-			if !isRetryable(err) {
-				return err
-			}
-			continue
-		}
-		
-		return nil
-	}
-	
-	return fmt.Errorf("operation failed after %d retries: %w", maxRetries, lastErr)
+// RateLimitedS3Client wraps an S3 client with a token-bucket rate limiter
+// and the SDK's own standard retryer. rate.Limiter is safe for concurrent
+// use, so a single RateLimitedS3Client is safe to reuse across callers even
+// though the enumerator itself only ever probes one digit at a time.
+type RateLimitedS3Client struct {
+	client  *s3.Client
+	limiter *rate.Limiter
 }
 
-// isRetryable determines if an error should trigger a retry
-func isRetryable(err error) bool {
-	if err == nil {
-		return false
+// NewRateLimitedS3Client creates a new rate-limited, retrying S3 client.
+// rps and burst configure the token bucket; non-positive values fall back
+// to sensible defaults. Additional s3.Options functional options (e.g.
+// UsePathStyle for S3-compatible providers) can be supplied via optFns.
+func NewRateLimitedS3Client(cfg aws.Config, rps float64, burst int, optFns ...func(*s3.Options)) *RateLimitedS3Client {
+	if rps <= 0 {
+		rps = defaultRPS
 	}
-	
-	// Check for common retryable AWS errors
-	errStr := err.Error()
-	retryableErrors := []string{
-		"RequestTimeout",
-		"ServiceUnavailable",
-		"Throttling",
-		"TooManyRequests",
-		"RequestLimitExceeded",
-		"SlowDown",
-		"RequestTimeTooSkewed",
-		"ProvisionedThroughputExceededException",
+	if burst <= 0 {
+		burst = defaultBurst
 	}
-	
-	for _, retryableErr := range retryableErrors {
-		if contains(errStr, retryableErr) {
-			return true
-		}
-	}
-	
-	return false
-}
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && s[:len(substr)] == substr || len(s) > len(substr) && contains(s[1:], substr)
-}
+	allOptFns := append([]func(*s3.Options){
+		func(o *s3.Options) {
+			o.Retryer = retry.AddWithMaxAttempts(retry.NewStandard(), defaultMaxAttempts)
+		},
+	}, optFns...)
 
-// RateLimitedS3Client wraps an S3 client with rate limiting
-type RateLimitedS3Client struct {
-	client    *s3.Client
-	rateLimit time.Duration
-	lastCall  time.Time
+	return &RateLimitedS3Client{
+		client:  s3.NewFromConfig(cfg, allOptFns...),
+		limiter: rate.NewLimiter(rate.Limit(rps), burst),
+	}
 }
 
-// NewRateLimitedS3Client creates a new rate-limited S3 client
-func NewRateLimitedS3Client(cfg aws.Config) *RateLimitedS3Client {
-	return &RateLimitedS3Client{
-		client:    s3.NewFromConfig(cfg),
-		rateLimit: rateLimitDelay,
-		lastCall:  time.Time{},
+// HeadBucketWithRetry probes bucket existence/access, honoring the rate
+// limit and the client's standard retryer.
+func (c *RateLimitedS3Client) HeadBucketWithRetry(ctx context.Context, input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
 	}
+	return c.client.HeadBucket(ctx, input)
 }
 
-// ListObjectsV2WithRetry lists S3 objects with retry logic and rate limiting
+// ListObjectsV2WithRetry lists a single page of S3 objects, honoring the
+// rate limit and the client's standard retryer.
 func (c *RateLimitedS3Client) ListObjectsV2WithRetry(ctx context.Context, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
-	var result *s3.ListObjectsV2Output
-	
-	err := WithRetry(ctx, func() error {
-		// Apply rate limiting
-		if !c.lastCall.IsZero() {
-			elapsed := time.Since(c.lastCall)
-			if elapsed < c.rateLimit {
-				time.Sleep(c.rateLimit - elapsed)
-			}
-		}
-		
-		var err error
-		result, err = c.client.ListObjectsV2(ctx, input)
-		c.lastCall = time.Now()
-		return err
-	})
-	
-	return result, err
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListObjectsV2(ctx, input)
 }
 
-// GetRetryConfig returns AWS config with retry configuration
-func GetRetryConfig(ctx context.Context, profile string, region string) (aws.Config, error) {
-	return config.LoadDefaultConfig(ctx,
-		config.WithSharedConfigProfile(profile),
-		config.WithRegion(region),
-		config.WithRetryMode(aws.RetryModeAdaptive),
-		config.WithRetryMaxAttempts(maxRetries),
-	)
-}
\ No newline at end of file
+// ListAllObjectsV2 fully paginates ListObjectsV2, so buckets with more
+// than 1000 keys are enumerated completely. Each page request honors the
+// rate limit.
+func (c *RateLimitedS3Client) ListAllObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input) ([]types.Object, error) {
+	var objects []types.Object
+
+	paginator := s3.NewListObjectsV2Paginator(c.client, input)
+	for paginator.HasMorePages() {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return objects, err
+		}
+
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, page.Contents...)
+	}
+
+	return objects, nil
+}
@@ -98,61 +98,3 @@ func DeleteS3Role(cfg aws.Config, ctx context.Context, roleName string) error {
 	return nil
 }
 
-// AttachInlinePolicy attaches an inline policy to the role for testing S3 access
-// with specific condition keys to enumerate the bucket owner's account ID
-func AttachInlinePolicy(cfg aws.Config, ctx context.Context, roleName string, bucketName string, accountIds []string) error {
-	client := iam.NewFromConfig(cfg)
-	
-	// Create policy that will fail unless the bucket is owned by one of the specified accounts
-	policyDoc := PolicyDocument{
-		Version: "2012-10-17",
-		Statement: []PolicyStatement{
-			{
-				Sid:    "TestS3Access",
-				Effect: "Allow",
-				Action: []string{"s3:ListBucket", "s3:GetObject"},
-				Resource: aws.String(fmt.Sprintf("arn:aws:s3:::%s/*", bucketName)),
-				Condition: map[string]map[string]interface{}{
-					"StringEquals": {
-						"s3:ExistingBucketPolicy": accountIds,
-					},
-				},
-			},
-		},
-	}
-	
-	policyBytes, err := json.Marshal(policyDoc)
-	if err != nil {
-		return fmt.Errorf("failed to marshal policy: %w", err)
-	}
-	
-	input := &iam.PutRolePolicyInput{
-		RoleName:       aws.String(roleName),
-		PolicyName:     aws.String("S3EnumerationPolicy"),
-		PolicyDocument: aws.String(string(policyBytes)),
-	}
-	
-	_, err = client.PutRolePolicy(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to attach inline policy: %w", err)
-	}
-	
-	return nil
-}
-
-// DetachInlinePolicy removes the inline policy from the role
-func DetachInlinePolicy(cfg aws.Config, ctx context.Context, roleName string) error {
-	client := iam.NewFromConfig(cfg)
-	
-	input := &iam.DeleteRolePolicyInput{
-		RoleName:   aws.String(roleName),
-		PolicyName: aws.String("S3EnumerationPolicy"),
-	}
-	
-	_, err := client.DeleteRolePolicy(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to detach inline policy: %w", err)
-	}
-	
-	return nil
-}
\ No newline at end of file
@@ -12,10 +12,11 @@ import (
 	"crypto/tls"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -24,29 +25,55 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/tclostio/s3-account-finder/internal"
+	"github.com/tclostio/s3-account-finder/internal/credprovider"
+	"github.com/tclostio/s3-account-finder/internal/enumerator"
+	"github.com/tclostio/s3-account-finder/internal/metrics"
 )
 
 func main() {
 	// Define command-line flags
 	var (
-		profile     = flag.String("profile", "Default", "AWS profile to use")
-		roleName    = flag.String("role-name", "s3-account-finder-role", "Role name for testing")
-		path        = flag.String("path", "", "Path to the S3 bucket (format: bucket/prefix)")
-		region      = flag.String("region", "us-east-1", "The AWS region to use")
-		delete      = flag.Bool("delete-existing-role", false, "Delete existing role if one exists")
-		insecureTLS = flag.Bool("insecure-tls", false, "Skip TLS certificate verification (use only with proxy)")
+		profile            = flag.String("profile", "Default", "AWS profile to use")
+		roleName           = flag.String("role-name", "s3-account-finder-role", "Role name for testing")
+		path               = flag.String("path", "", "Path to the S3 bucket (format: bucket/prefix)")
+		region             = flag.String("region", "us-east-1", "The AWS region to use")
+		delete             = flag.Bool("delete-existing-role", false, "Delete existing role if one exists")
+		insecureTLS        = flag.Bool("insecure-tls", false, "Skip TLS certificate verification (use only with proxy)")
+		concurrency        = flag.Int("concurrency", 4, "Reserved for a future version that probes candidate digits in parallel; currently a no-op, digits are always probed one at a time")
+		consistencyWait    = flag.Duration("consistency-wait", 2*time.Second, "Delay between attaching the enumeration policy and probing the bucket, to allow for IAM eventual consistency")
+		consistencyTimeout = flag.Duration("consistency-timeout", 20*time.Second, "Total time to retry a single digit probe before giving up on it as inconclusive")
+		startingPrefix     = flag.String("starting-prefix", "", "Resume enumeration from a previously-known account ID prefix")
+		endpointURL        = flag.String("endpoint-url", "", "Custom S3/STS endpoint URL, for S3-compatible providers (MinIO, R2, Wasabi, Ceph RGW)")
+		usePathStyle       = flag.Bool("use-path-style", false, "Use path-style addressing (bucket.example.com/key becomes example.com/bucket/key)")
+		disableSSL         = flag.Bool("disable-ssl", false, "Use http:// instead of https:// for an --endpoint-url given without a scheme")
+		authMode           = flag.String("auth-mode", "chain", "Credential source: chain, profile, env, imds, webidentity, sso, or process")
+		webIdentityToken   = flag.String("web-identity-token-file", "", "Path to a web identity token file, for --auth-mode=webidentity (e.g. an IRSA-projected service account token)")
+		webIdentityRoleArn = flag.String("web-identity-role-arn", "", "Role ARN to assume via AssumeRoleWithWebIdentity, for --auth-mode=webidentity")
+		roleSessionName    = flag.String("role-session-name", "s3-account-finder-session", "Session name used when assuming a role via STS")
+		externalID         = flag.String("external-id", "", "External ID required by the assumed role's trust policy, if any")
+		metricsListen      = flag.String("metrics-listen", "", "Address to serve Prometheus /metrics on (e.g. :9090); disabled if empty")
+		rps                = flag.Float64("rps", 10, "Max S3 bucket-probe requests per second during enumeration")
+		burst              = flag.Int("burst", 5, "Token-bucket burst size for the rate limiter")
 	)
 	flag.Parse()
 
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	if *path == "" {
 		flag.Usage()
 		return
 	}
 
+	if *metricsListen != "" {
+		srv := metrics.StartServer(*metricsListen)
+		defer srv.Close()
+		slog.Info("metrics server listening", "addr", *metricsListen)
+	}
+
 	// Configure HTTP client
 	var client *http.Client
 	if *insecureTLS {
-		fmt.Println("[WARNING] TLS certificate verification disabled - use only in controlled environments")
+		slog.Warn("TLS certificate verification disabled - use only in controlled environments")
 		tr := &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		}
@@ -57,107 +84,162 @@ func main() {
 
 	// setting context and AWS config
 	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(*profile),
+
+	credProvider, err := credprovider.Resolve(ctx, credprovider.Options{
+		AuthMode:             credprovider.AuthMode(*authMode),
+		Profile:              *profile,
+		Region:               *region,
+		WebIdentityTokenFile: *webIdentityToken,
+		WebIdentityRoleArn:   *webIdentityRoleArn,
+		RoleSessionName:      *roleSessionName,
+		ExternalID:           *externalID,
+	})
+	if err != nil {
+		slog.Error("failed to resolve credentials", "auth_mode", *authMode, "error", err)
+		os.Exit(1)
+	}
+
+	configOpts := []func(*config.LoadOptions) error{
+		config.WithCredentialsProvider(credProvider),
 		config.WithRegion(*region),
-		config.WithHTTPClient(client))
+		config.WithHTTPClient(client),
+	}
+	if *endpointURL != "" {
+		configOpts = append(configOpts, config.WithEndpointResolverWithOptions(customEndpointResolver(*endpointURL, *disableSSL)))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, configOpts...)
 	if err != nil {
-		log.Println(fmt.Errorf("failed to load AWS config: %w", err))
+		slog.Error("failed to load AWS config", "error", err)
 		os.Exit(1)
 	}
 
 	stsClient := sts.NewFromConfig(cfg)
 	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
-		log.Println(fmt.Errorf("failed to get caller identity: %w", err))
+		slog.Error("failed to get caller identity", "error", err)
 		os.Exit(1)
 	}
 	userArn := aws.ToString(identity.Arn)
 
 	// check if role exists in testing account
-	iamClient := iam.NewFromConfig(cfg)
+	iamClient := iam.NewFromConfig(cfg, func(o *iam.Options) {
+		o.APIOptions = append(o.APIOptions, metrics.InstrumentAPIOptions("iam"))
+	})
 	roleInput := &iam.GetRoleInput{
 		RoleName: aws.String(*roleName),
 	}
 	roleInfo, err := iamClient.GetRole(ctx, roleInput)
 	if err == nil && roleInfo.Role != nil {
-		fmt.Printf("[!] Info: role %s already exists in account.\n", *roleName)
+		slog.Info("role already exists in account", "role_name", *roleName)
 		if *delete {
-			fmt.Printf("Deleting existing role %s\n", *roleName)
+			slog.Info("deleting existing role", "role_name", *roleName)
 			err = internal.DeleteS3Role(cfg, ctx, *roleName)
 			if err != nil {
-				log.Fatalf("Failed to delete existing role: %v", err)
+				slog.Error("failed to delete existing role", "error", err)
+				os.Exit(1)
 			}
 		} else {
-			log.Fatal("Role already exists. Use --delete-existing-role to remove it first")
+			slog.Error("role already exists; use --delete-existing-role to remove it first", "role_name", *roleName)
+			os.Exit(1)
 		}
 	}
 
 	role, err := internal.CreateS3Role(cfg, ctx, *roleName, userArn)
 	if err != nil {
-		log.Fatalf("Failed to create role: %v", err)
+		slog.Error("failed to create role", "error", err)
+		os.Exit(1)
 	}
-	
+
 	// Ensure cleanup on exit
 	defer func() {
-		fmt.Printf("\n[*] Cleaning up role %s\n", *roleName)
+		slog.Info("cleaning up role", "role_name", *roleName)
 		if err := internal.DeleteS3Role(cfg, ctx, *roleName); err != nil {
-			log.Printf("Warning: Failed to delete role: %v", err)
+			slog.Warn("failed to delete role", "role_name", *roleName, "error", err)
 		}
 	}()
 
-	assumeRoleProvider := stscreds.NewAssumeRoleProvider(stsClient, *role.Arn)
-	assumedCfg, err := config.LoadDefaultConfig(ctx,
+	// The assumed-role session credentials take over from credProvider
+	// here, but stsClient itself (used to call AssumeRole) was built from
+	// cfg, which already carries credProvider - so bootstrapping from
+	// IMDS or web identity doesn't silently fall back to a default
+	// profile partway through.
+	assumeRoleProvider := stscreds.NewAssumeRoleProvider(stsClient, *role.Arn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = *roleSessionName
+		if *externalID != "" {
+			o.ExternalID = aws.String(*externalID)
+		}
+	})
+	assumedConfigOpts := []func(*config.LoadOptions) error{
 		config.WithRegion(*region),
 		config.WithCredentialsProvider(assumeRoleProvider),
 		config.WithHTTPClient(client),
-	)
+	}
+	if *endpointURL != "" {
+		assumedConfigOpts = append(assumedConfigOpts, config.WithEndpointResolverWithOptions(customEndpointResolver(*endpointURL, *disableSSL)))
+	}
+
+	assumedCfg, err := config.LoadDefaultConfig(ctx, assumedConfigOpts...)
 	if err != nil {
-		log.Println(fmt.Errorf("failed to assume role: %w", err))
+		slog.Error("failed to assume role", "error", err)
 		os.Exit(1)
 	}
 
-	// parse bucket and prefix from path (format: bucket/prefix)
-	if *path == "" {
-		log.Fatal("Path is required. Use format: bucket/prefix or just bucket")
-	}
-	
-	parts := strings.SplitN(*path, "/", 2)
-	bucket := parts[0]
-	var prefix string
-	if len(parts) > 1 {
-		prefix = parts[1]
-	}
-	
+	// parse bucket from path (format: bucket/prefix); the enumeration
+	// algorithm only needs the bucket name, any prefix is ignored.
+	bucket := strings.SplitN(*path, "/", 2)[0]
+
 	if bucket == "" {
-		log.Fatal("Invalid path: bucket name cannot be empty")
+		slog.Error("invalid path: bucket name cannot be empty")
+		os.Exit(1)
 	}
-	
-	fmt.Printf("[*] Testing S3 bucket: %s\n", bucket)
-	if prefix != "" {
-		fmt.Printf("[*] With prefix: %s\n", prefix)
+
+	slog.Info("enumerating owning account", "bucket", bucket, "starting_prefix", *startingPrefix)
+
+	s3OptFns := []func(*s3.Options){
+		func(o *s3.Options) { o.APIOptions = append(o.APIOptions, metrics.InstrumentAPIOptions("s3")) },
+	}
+	if *usePathStyle {
+		s3OptFns = append(s3OptFns, func(o *s3.Options) { o.UsePathStyle = true })
 	}
 
-	// Use rate-limited S3 client with retry logic
-	s3Client := internal.NewRateLimitedS3Client(assumedCfg)
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
+	iamOptFns := []func(*iam.Options){
+		func(o *iam.Options) { o.APIOptions = append(o.APIOptions, metrics.InstrumentAPIOptions("iam")) },
 	}
 
-	fmt.Println("\n[*] Attempting to list S3 objects (with retry logic)...")
-	resp, err := s3Client.ListObjectsV2WithRetry(ctx, input)
+	accountID, audit, err := enumerator.EnumerateBucketOwner(ctx, assumedCfg, *roleName, bucket, enumerator.Options{
+		Concurrency:        *concurrency,
+		ConsistencyWait:    *consistencyWait,
+		ConsistencyTimeout: *consistencyTimeout,
+		StartingPrefix:     *startingPrefix,
+		S3OptFns:           s3OptFns,
+		IAMOptFns:          iamOptFns,
+		RPS:                *rps,
+		Burst:              *burst,
+	})
 	if err != nil {
-		log.Fatalf("Failed to list S3 objects: %v", err)
+		slog.Error("failed to enumerate bucket owner account", "error", err)
+		os.Exit(1)
 	}
-	
-	if len(resp.Contents) == 0 {
-		fmt.Println("[!] No objects found or access denied")
-	} else {
-		fmt.Printf("\n[+] Found %d objects:\n", len(resp.Contents))
-		for _, obj := range resp.Contents {
-			fmt.Printf("  - %s\n", *obj.Key)
+
+	metrics.EnumerationDigitsResolved.Set(float64(len(accountID)))
+	slog.Info("discovered owning account", "account_id", accountID, "digit_probes", len(audit))
+}
+
+// customEndpointResolver routes every AWS service (S3, STS, IAM) at a
+// fixed URL, for targeting S3-compatible providers such as MinIO,
+// Cloudflare R2, Wasabi, or Ceph RGW instead of AWS itself.
+func customEndpointResolver(endpointURL string, disableSSL bool) aws.EndpointResolverWithOptionsFunc {
+	url := endpointURL
+	if !strings.Contains(url, "://") {
+		scheme := "https"
+		if disableSSL {
+			scheme = "http"
 		}
+		url = fmt.Sprintf("%s://%s", scheme, url)
+	}
+
+	return func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		return aws.Endpoint{URL: url, SigningRegion: region}, nil
 	}
-	
-	fmt.Println("\n[+] Operation completed successfully")
 }